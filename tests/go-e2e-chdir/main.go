@@ -0,0 +1,135 @@
+package main
+
+/*
+#include <dirent.h>
+#include <fcntl.h>
+#include <unistd.h>
+#include <stdlib.h>
+
+// open(2) is C-variadic (the mode argument only applies with O_CREAT),
+// which cgo can't call directly; this fixed-arity wrapper covers the
+// O_RDONLY-without-O_CREAT case this test needs.
+static int open_rdonly(const char *path) {
+	return open(path, O_RDONLY);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+)
+
+const PAYLOAD = "Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat. Duis aute irure dolor in reprehenderit in voluptate velit esse cillum dolore eu fugiat nulla pariatur. Excepteur sint occaecat cupidatat non proident, sunt in culpa qui officia deserunt mollit anim id est laborum."
+
+// os.Chdir/os.Getwd/(*os.File).Chdir all issue raw syscalls directly, never
+// going through libc, so this drives chdir/fchdir/getcwd through cgo
+// instead to actually exercise the layer's interposed symbols.
+
+func cChdir(path string) error {
+	p := C.CString(path)
+	defer C.free(unsafe.Pointer(p))
+	ret, err := C.chdir(p)
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cGetwd() (string, error) {
+	buf := make([]byte, 4096)
+	ptr, err := C.getcwd((*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)))
+	if ptr == nil {
+		return "", err
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(&buf[0]))), nil
+}
+
+// cReadRelative opens and reads a relative path through cgo, the only way
+// to actually drive it through the layer's openat hook (and, by extension,
+// resolve_at_fdcwd against the shadow cwd chdir/fchdir set) -- os.ReadFile
+// issues a raw openat syscall directly and never reaches it.
+func cReadRelative(path string) ([]byte, error) {
+	p := C.CString(path)
+	defer C.free(unsafe.Pointer(p))
+	fd, err := C.open_rdonly(p)
+	if fd < 0 {
+		return nil, err
+	}
+	defer C.close(fd)
+
+	buf := make([]byte, 4096)
+	n, err := C.read(fd, unsafe.Pointer(&buf[0]), C.size_t(len(buf)))
+	if n < 0 {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// chdir into /app then open "test.txt" by a relative path: a correct layer
+// rewrites the relative path against the shadow remote cwd before it ever
+// reaches the agent.
+func TestChdirRelativeOpen() {
+	if err := cChdir("/app"); err != nil {
+		panic(err)
+	}
+
+	dat, err := cReadRelative("test.txt")
+	if err != nil {
+		panic(err)
+	}
+	if string(dat) != PAYLOAD {
+		panic(fmt.Errorf("expected %s, got %s", PAYLOAD, string(dat)))
+	}
+}
+
+func TestGetwd() {
+	wd, err := cGetwd()
+	if err != nil {
+		panic(err)
+	}
+	if wd != "/app" {
+		panic(fmt.Errorf("expected cwd /app, got %s", wd))
+	}
+}
+
+// Same as above, but arriving at the remote cwd via fchdir on an
+// already-open remote directory fd instead of chdir on a path string. The
+// directory is opened through cgo's opendir (os.Open is a raw syscall) so
+// the layer's opendir hook registers its fd as remote.
+func TestFchdir() {
+	if err := cChdir("/"); err != nil {
+		panic(err)
+	}
+
+	p := C.CString("/app")
+	defer C.free(unsafe.Pointer(p))
+	dirp, err := C.opendir(p)
+	if dirp == nil {
+		panic(err)
+	}
+	defer C.closedir(dirp)
+
+	ret, err := C.fchdir(C.dirfd(dirp))
+	if ret != 0 {
+		panic(err)
+	}
+
+	dat, err := cReadRelative("test.txt")
+	if err != nil {
+		panic(err)
+	}
+	if string(dat) != PAYLOAD {
+		panic(fmt.Errorf("expected %s, got %s", PAYLOAD, string(dat)))
+	}
+}
+
+func main() {
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Chdir: relative open resolves remotely ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestChdirRelativeOpen()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Chdir: Getwd reflects shadow cwd ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestGetwd()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Chdir: Fchdir on a remote dir fd ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestFchdir()
+}
@@ -0,0 +1,199 @@
+package main
+
+/*
+#include <dirent.h>
+#include <stdlib.h>
+#include <errno.h>
+
+// telldir/seekdir take a long, which cgo can call directly, but wrapping
+// them avoids repeating the cast at every call site below.
+static long call_telldir(DIR *dirp) { return telldir(dirp); }
+static void call_seekdir(DIR *dirp, long loc) { seekdir(dirp, loc); }
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"unsafe"
+)
+
+// DIR is a subdirectory the test populates itself (rather than relying on
+// fixtures baked into the test image), so ENTRY_COUNT is guaranteed to
+// actually be backed by remote files.
+const DIR = "/app/many"
+
+// ENTRY_COUNT is several multiples of the layer's getdents64 batch size, to
+// force the buffered readdir hook to refill from the agent more than once
+// for every test below.
+const ENTRY_COUNT = 4096
+
+// os.ReadDir/fs.WalkDir drive getdents64 as a raw syscall, which never
+// reaches an LD_PRELOADed opendir/readdir/seekdir/telldir, so every
+// directory traversal below goes through cgo calls to those libc names
+// instead -- the ones the layer's readdir hooks actually shadow.
+func cOpendir(path string) *C.DIR {
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	dirp := C.opendir(cPath)
+	if dirp == nil {
+		panic(fmt.Errorf("opendir(%s) failed", path))
+	}
+	return dirp
+}
+
+// cReaddirNames drains dirp to completion (skipping "." and ".."); closing
+// it is left to the caller.
+func cReaddirNames(dirp *C.DIR) []string {
+	var names []string
+	for {
+		entry := C.readdir(dirp)
+		if entry == nil {
+			break
+		}
+		name := C.GoString(&entry.d_name[0])
+		if name == "." || name == ".." {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func seedDir() {
+	if err := os.Mkdir(DIR, 0755); err != nil {
+		panic(err)
+	}
+	for i := 0; i < ENTRY_COUNT; i++ {
+		name := fmt.Sprintf("%s/file_%05d.txt", DIR, i)
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func expectedNames() []string {
+	names := make([]string, 0, ENTRY_COUNT)
+	for i := 0; i < ENTRY_COUNT; i++ {
+		names = append(names, fmt.Sprintf("file_%05d.txt", i))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TestReadDir drains the whole directory stream via readdir(3), to make
+// sure every batch the agent streams back is accounted for.
+func TestReadDir() {
+	dirp := cOpendir(DIR)
+	names := cReaddirNames(dirp)
+	C.closedir(dirp)
+
+	if len(names) != ENTRY_COUNT {
+		panic(fmt.Errorf("expected %d entries, got %d", ENTRY_COUNT, len(names)))
+	}
+	sort.Strings(names)
+	want := expectedNames()
+	for i, name := range names {
+		if name != want[i] {
+			panic(fmt.Errorf("entry %d: expected %s, got %s", i, want[i], name))
+		}
+	}
+}
+
+// TestReopenReadDir opens a second, independent stream on the same
+// directory to check the agent's per-fd cursor state doesn't leak across
+// streams.
+func TestReopenReadDir() {
+	dirp := cOpendir(DIR)
+	names := cReaddirNames(dirp)
+	C.closedir(dirp)
+
+	if len(names) != len(expectedNames()) {
+		panic(fmt.Errorf("second opendir: expected %d entries, got %d", len(expectedNames()), len(names)))
+	}
+}
+
+// TestSeekTellDir exercises telldir/seekdir by partially consuming the
+// stream, remembering a cursor position with telldir, reading further,
+// then seeking back to the remembered position with seekdir and checking
+// the same entries come back again. A seek that only resets local
+// bookkeeping without round-tripping to the agent's own directory iterator
+// would instead resume from wherever the iterator happened to be, not from
+// the remembered position, and this would catch that.
+func TestSeekTellDir() {
+	dirp := cOpendir(DIR)
+	defer C.closedir(dirp)
+
+	var first []string
+	for len(first) < ENTRY_COUNT/2 {
+		entry := C.readdir(dirp)
+		if entry == nil {
+			panic(fmt.Errorf("ran out of entries reading first half"))
+		}
+		name := C.GoString(&entry.d_name[0])
+		if name == "." || name == ".." {
+			continue
+		}
+		first = append(first, name)
+	}
+
+	mark := int64(C.call_telldir(dirp))
+
+	var rest []string
+	for {
+		entry := C.readdir(dirp)
+		if entry == nil {
+			break
+		}
+		name := C.GoString(&entry.d_name[0])
+		if name == "." || name == ".." {
+			continue
+		}
+		rest = append(rest, name)
+	}
+	if len(first)+len(rest) != ENTRY_COUNT {
+		panic(fmt.Errorf("expected %d total names, got %d", ENTRY_COUNT, len(first)+len(rest)))
+	}
+
+	C.call_seekdir(dirp, C.long(mark))
+	var replay []string
+	for {
+		entry := C.readdir(dirp)
+		if entry == nil {
+			break
+		}
+		name := C.GoString(&entry.d_name[0])
+		if name == "." || name == ".." {
+			continue
+		}
+		replay = append(replay, name)
+	}
+	if len(replay) != len(rest) {
+		panic(fmt.Errorf("seekdir back to mark: expected %d names, got %d", len(rest), len(replay)))
+	}
+	for i := range rest {
+		if replay[i] != rest[i] {
+			panic(fmt.Errorf("seekdir back to mark: entry %d expected %s, got %s", i, rest[i], replay[i]))
+		}
+	}
+
+	all := append(first, rest...)
+	sort.Strings(all)
+	want := expectedNames()
+	for i := range want {
+		if all[i] != want[i] {
+			panic(fmt.Errorf("entry %d: expected %s, got %s", i, want[i], all[i]))
+		}
+	}
+}
+
+func main() {
+	seedDir()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing ReadDir ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestReadDir()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Reopen ReadDir ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestReopenReadDir()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing SeekTellDir ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestSeekTellDir()
+}
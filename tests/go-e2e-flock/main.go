@@ -0,0 +1,196 @@
+package main
+
+/*
+#include <fcntl.h>
+#include <unistd.h>
+#include <string.h>
+#include <stdlib.h>
+#include <sys/file.h>
+
+// fcntl(2) is C-variadic, which cgo can't call directly; this fixed-arity
+// wrapper covers the lock commands (F_SETLK/F_SETLKW/F_GETLK) this test
+// needs, the same shape the layer's own fcntl hook expects.
+static int fcntl_lock(int fd, int cmd, struct flock *lock) {
+	return fcntl(fd, cmd, lock);
+}
+
+static int open_rdwr(const char *path) {
+	return open(path, O_RDWR);
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+	"unsafe"
+)
+
+const SEED = "Locked in."
+
+// childMode is the flag a re-exec'd child process checks for, so the same
+// binary can play both the lock holder and the contending process.
+const childMode = "--flock-child"
+
+// os.OpenFile/syscall.FcntlFlock/syscall.Flock all issue raw syscalls
+// directly, never going through libc, so opening and locking here goes
+// through cgo instead to actually exercise the layer's openat/flock/fcntl
+// hooks.
+
+func cOpenRdwr(path string) (C.int, error) {
+	p := C.CString(path)
+	defer C.free(unsafe.Pointer(p))
+	fd, err := C.open_rdwr(p)
+	if fd < 0 {
+		return fd, err
+	}
+	return fd, nil
+}
+
+func cFlock(fd C.int, operation C.int) error {
+	ret, err := C.flock(fd, operation)
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+// Acquires a POSIX fcntl write lock, confirms a redundant F_GETLK from the
+// same fd reports no conflicting lock, then releases it.
+func TestFcntlFlock() {
+	path := "/app/fcntl_lock.txt"
+	if err := os.WriteFile(path, []byte(SEED), 0644); err != nil {
+		panic(err)
+	}
+
+	fd, err := cOpenRdwr(path)
+	if err != nil {
+		panic(err)
+	}
+	defer C.close(fd)
+
+	var lock C.struct_flock
+	C.memset(unsafe.Pointer(&lock), 0, C.sizeof_struct_flock)
+	lock.l_type = C.F_WRLCK
+	if ret, err := C.fcntl_lock(fd, C.F_SETLK, &lock); ret != 0 {
+		panic(err)
+	}
+
+	var check C.struct_flock
+	C.memset(unsafe.Pointer(&check), 0, C.sizeof_struct_flock)
+	check.l_type = C.F_WRLCK
+	if ret, err := C.fcntl_lock(fd, C.F_GETLK, &check); ret != 0 {
+		panic(err)
+	}
+	if check.l_type != C.F_UNLCK {
+		panic(fmt.Errorf("expected F_UNLCK for our own lock via F_GETLK, got %d", check.l_type))
+	}
+
+	var unlock C.struct_flock
+	C.memset(unsafe.Pointer(&unlock), 0, C.sizeof_struct_flock)
+	unlock.l_type = C.F_UNLCK
+	if ret, err := C.fcntl_lock(fd, C.F_SETLK, &unlock); ret != 0 {
+		panic(err)
+	}
+}
+
+func TestFlock() {
+	path := "/app/flock.txt"
+	if err := os.WriteFile(path, []byte(SEED), 0644); err != nil {
+		panic(err)
+	}
+
+	fd, err := cOpenRdwr(path)
+	if err != nil {
+		panic(err)
+	}
+	defer C.close(fd)
+
+	if err := cFlock(fd, C.LOCK_EX); err != nil {
+		panic(err)
+	}
+	if err := cFlock(fd, C.LOCK_UN); err != nil {
+		panic(err)
+	}
+
+	if err := cFlock(fd, C.LOCK_SH); err != nil {
+		panic(err)
+	}
+	if err := cFlock(fd, C.LOCK_UN); err != nil {
+		panic(err)
+	}
+}
+
+// TestFlockContention re-execs this binary as a child process that takes an
+// exclusive lock and holds it, then verifies a non-blocking lock attempt
+// from this process fails while the child holds it, and that a blocking
+// lock call unblocks once the child exits (and releases the lock on exit).
+func TestFlockContention() {
+	path := "/app/flock_contention.txt"
+	if err := os.WriteFile(path, []byte(SEED), 0644); err != nil {
+		panic(err)
+	}
+
+	child := exec.Command(os.Args[0], childMode, path)
+	child.Stdout = os.Stdout
+	child.Stderr = os.Stderr
+	if err := child.Start(); err != nil {
+		panic(err)
+	}
+
+	// Give the child a moment to acquire the lock before we contend for it.
+	time.Sleep(500 * time.Millisecond)
+
+	fd, err := cOpenRdwr(path)
+	if err != nil {
+		panic(err)
+	}
+	defer C.close(fd)
+
+	if err := cFlock(fd, C.LOCK_EX|C.LOCK_NB); err == nil {
+		panic(fmt.Errorf("expected non-blocking lock to fail while child holds it"))
+	}
+
+	// Blocking lock should succeed once the child releases the lock on exit.
+	if err := cFlock(fd, C.LOCK_EX); err != nil {
+		panic(err)
+	}
+	if err := cFlock(fd, C.LOCK_UN); err != nil {
+		panic(err)
+	}
+
+	if err := child.Wait(); err != nil {
+		panic(err)
+	}
+}
+
+// runChild is the body executed when re-exec'd with childMode: it takes an
+// exclusive flock on the given path and holds it until killed or timed out.
+func runChild(path string) {
+	fd, err := cOpenRdwr(path)
+	if err != nil {
+		panic(err)
+	}
+	defer C.close(fd)
+
+	if err := cFlock(fd, C.LOCK_EX); err != nil {
+		panic(err)
+	}
+	time.Sleep(1 * time.Second)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == childMode {
+		runChild(os.Args[2])
+		return
+	}
+
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Fcntl Flock ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestFcntlFlock()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Flock ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestFlock()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Flock Contention ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestFlockContention()
+}
@@ -0,0 +1,347 @@
+package main
+
+/*
+#define _GNU_SOURCE
+#include <fcntl.h>
+#include <unistd.h>
+#include <stdlib.h>
+#include <sys/stat.h>
+
+// Not declared by this libc's headers even with _GNU_SOURCE, despite the
+// kernel syscall existing; declared by hand so cgo can call it directly.
+extern int renameat2(int olddirfd, const char *oldpath, int newdirfd, const char *newpath, unsigned int flags);
+
+// open(2) is C-variadic (the mode argument only applies with O_CREAT),
+// which cgo can't call directly; this fixed-arity wrapper covers the
+// O_RDWR-without-O_CREAT case this test needs.
+static int open_rdwr(const char *path) {
+	return open(path, O_RDWR);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const CONTENTS = "Link it up."
+
+// The os package's Rename/Link/Symlink/... issue raw syscalls directly
+// (never going through libc), so every operation this test means to
+// exercise a layer hook for goes through cgo calls to the exact *at names
+// the layer hooks (renameat2, linkat, symlinkat, readlinkat, unlinkat,
+// mkdirat, fchmodat, truncate, ftruncate) instead.
+
+func cPath(path string) (*C.char, func()) {
+	c := C.CString(path)
+	return c, func() { C.free(unsafe.Pointer(c)) }
+}
+
+func cRenameat2(oldPath, newPath string) error {
+	old, freeOld := cPath(oldPath)
+	defer freeOld()
+	new, freeNew := cPath(newPath)
+	defer freeNew()
+	ret, err := C.renameat2(C.AT_FDCWD, old, C.AT_FDCWD, new, 0)
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cLinkat(oldPath, newPath string) error {
+	old, freeOld := cPath(oldPath)
+	defer freeOld()
+	new, freeNew := cPath(newPath)
+	defer freeNew()
+	ret, err := C.linkat(C.AT_FDCWD, old, C.AT_FDCWD, new, 0)
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cSymlinkat(target, linkPath string) error {
+	t, freeT := cPath(target)
+	defer freeT()
+	l, freeL := cPath(linkPath)
+	defer freeL()
+	ret, err := C.symlinkat(t, C.AT_FDCWD, l)
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cReadlinkat(path string) (string, error) {
+	p, freeP := cPath(path)
+	defer freeP()
+	buf := make([]byte, 4096)
+	n, err := C.readlinkat(C.AT_FDCWD, p, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)))
+	if n < 0 {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+func cUnlinkat(path string, removeDir bool) error {
+	p, freeP := cPath(path)
+	defer freeP()
+	var flags C.int
+	if removeDir {
+		flags = C.AT_REMOVEDIR
+	}
+	ret, err := C.unlinkat(C.AT_FDCWD, p, flags)
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cMkdirat(path string, mode uint32) error {
+	p, freeP := cPath(path)
+	defer freeP()
+	ret, err := C.mkdirat(C.AT_FDCWD, p, C.mode_t(mode))
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cFchmodat(path string, mode uint32) error {
+	p, freeP := cPath(path)
+	defer freeP()
+	ret, err := C.fchmodat(C.AT_FDCWD, p, C.mode_t(mode), 0)
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cTruncate(path string, length int64) error {
+	p, freeP := cPath(path)
+	defer freeP()
+	ret, err := C.truncate(p, C.off_t(length))
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cFtruncate(fd C.int, length int64) error {
+	ret, err := C.ftruncate(fd, C.off_t(length))
+	if ret != 0 {
+		return err
+	}
+	return nil
+}
+
+func cRemoveRecursively(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return cUnlinkat(path, false)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := cRemoveRecursively(path + "/" + entry.Name()); err != nil {
+			return err
+		}
+	}
+	return cUnlinkat(path, true)
+}
+
+func TestRename() {
+	if err := os.WriteFile("/app/rename_src.txt", []byte(CONTENTS), 0644); err != nil {
+		panic(err)
+	}
+	if err := cRenameat2("/app/rename_src.txt", "/app/rename_dst.txt"); err != nil {
+		panic(err)
+	}
+	dat, err := os.ReadFile("/app/rename_dst.txt")
+	if err != nil {
+		panic(err)
+	}
+	if string(dat) != CONTENTS {
+		panic(fmt.Errorf("expected %s, got %s", CONTENTS, string(dat)))
+	}
+}
+
+func TestLink() {
+	if err := os.WriteFile("/app/link_src.txt", []byte(CONTENTS), 0644); err != nil {
+		panic(err)
+	}
+	if err := cLinkat("/app/link_src.txt", "/app/link_dst.txt"); err != nil {
+		panic(err)
+	}
+	dat, err := os.ReadFile("/app/link_dst.txt")
+	if err != nil {
+		panic(err)
+	}
+	if string(dat) != CONTENTS {
+		panic(fmt.Errorf("expected %s, got %s", CONTENTS, string(dat)))
+	}
+}
+
+func TestSymlink() {
+	if err := os.WriteFile("/app/symlink_target.txt", []byte(CONTENTS), 0644); err != nil {
+		panic(err)
+	}
+	if err := cSymlinkat("/app/symlink_target.txt", "/app/symlink.txt"); err != nil {
+		panic(err)
+	}
+	target, err := cReadlinkat("/app/symlink.txt")
+	if err != nil {
+		panic(err)
+	}
+	if target != "/app/symlink_target.txt" {
+		panic(fmt.Errorf("expected target /app/symlink_target.txt, got %s", target))
+	}
+	dat, err := os.ReadFile("/app/symlink.txt")
+	if err != nil {
+		panic(err)
+	}
+	if string(dat) != CONTENTS {
+		panic(fmt.Errorf("expected %s, got %s", CONTENTS, string(dat)))
+	}
+}
+
+// TestSymlinkLoop builds a two-link symlink cycle through the symlinkat
+// hook, then checks that opening a path through it fails with ELOOP, the
+// same way it would for an unhooked local path.
+func TestSymlinkLoop() {
+	if err := cSymlinkat("/app/loop_b", "/app/loop_a"); err != nil {
+		panic(err)
+	}
+	if err := cSymlinkat("/app/loop_a", "/app/loop_b"); err != nil {
+		panic(err)
+	}
+	if _, err := os.ReadFile("/app/loop_a"); !errors.Is(err, syscall.ELOOP) {
+		panic(fmt.Errorf("expected ELOOP reading a symlink cycle, got %v", err))
+	}
+}
+
+func TestMkdirAndRemove() {
+	if err := cMkdirat("/app/dir_a", 0755); err != nil {
+		panic(err)
+	}
+	if err := cMkdirat("/app/dir_b", 0755); err != nil {
+		panic(err)
+	}
+	if err := cMkdirat("/app/dir_b/nested", 0755); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("/app/dir_b/nested/file.txt", []byte(CONTENTS), 0644); err != nil {
+		panic(err)
+	}
+	if err := cUnlinkat("/app/dir_a", true); err != nil {
+		panic(err)
+	}
+	if err := cRemoveRecursively("/app/dir_b"); err != nil {
+		panic(err)
+	}
+	if _, err := os.Stat("/app/dir_b"); !os.IsNotExist(err) {
+		panic(fmt.Errorf("expected /app/dir_b to be gone, got err: %v", err))
+	}
+}
+
+func TestChmodAndTruncate() {
+	path := "/app/chmod_truncate.txt"
+	if err := os.WriteFile(path, []byte(CONTENTS), 0644); err != nil {
+		panic(err)
+	}
+	if err := cFchmodat(path, 0600); err != nil {
+		panic(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		panic(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		panic(fmt.Errorf("expected mode 0600, got %o", info.Mode().Perm()))
+	}
+	if err := cTruncate(path, 5); err != nil {
+		panic(err)
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	if string(dat) != CONTENTS[:5] {
+		panic(fmt.Errorf("expected %s, got %s", CONTENTS[:5], string(dat)))
+	}
+}
+
+// TestFtruncate exercises the fd-based ftruncate hook, which needs the
+// openat hook's local-fd -> remote-fd table rather than just resolving a
+// path the way truncate does.
+func TestFtruncate() {
+	path := "/app/ftruncate.txt"
+	if err := os.WriteFile(path, []byte(CONTENTS), 0644); err != nil {
+		panic(err)
+	}
+
+	p, freeP := cPath(path)
+	defer freeP()
+	fd, err := C.open_rdwr(p)
+	if fd < 0 {
+		panic(err)
+	}
+	defer C.close(fd)
+
+	if err := cFtruncate(fd, 4); err != nil {
+		panic(err)
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	if string(dat) != CONTENTS[:4] {
+		panic(fmt.Errorf("expected %s, got %s", CONTENTS[:4], string(dat)))
+	}
+}
+
+// TestRenameCrossDevice relies on /app being mounted as a distinct
+// filesystem from /tmp in the test pod, so renaming between them hits the
+// agent's real renameat2(2) and gets back a genuine EXDEV rather than the
+// layer special-casing the error.
+func TestRenameCrossDevice() {
+	if err := os.WriteFile("/tmp/cross_device.txt", []byte(CONTENTS), 0644); err != nil {
+		panic(err)
+	}
+	err := cRenameat2("/tmp/cross_device.txt", "/app/cross_device.txt")
+	if !errors.Is(err, syscall.EXDEV) {
+		panic(fmt.Errorf("expected EXDEV renaming across filesystems, got %v", err))
+	}
+}
+
+func main() {
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Rename ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestRename()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Link ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestLink()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Symlink ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestSymlink()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Symlink Loop ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestSymlinkLoop()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Mkdir And Remove ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestMkdirAndRemove()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Chmod And Truncate ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestChmodAndTruncate()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Ftruncate ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestFtruncate()
+	fmt.Println("~~~~~~~~~~~~~~~~~~~~~~~~ Testing Rename Cross Device ~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+	TestRenameCrossDevice()
+}